@@ -0,0 +1,207 @@
+package patchwork
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"time"
+)
+
+type (
+	imageFS struct {
+		img *Image
+	}
+
+	fileInfo struct {
+		name   string
+		record *directoryRecord
+	}
+
+	dirEntry struct {
+		record *directoryRecord
+	}
+
+	file struct {
+		name   string
+		record *directoryRecord
+		r      io.Reader
+	}
+
+	dir struct {
+		name    string
+		record  *directoryRecord
+		entries []*directoryRecord
+		offset  int
+	}
+)
+
+var (
+	_ fs.FS        = (*imageFS)(nil)
+	_ fs.ReadDirFS = (*imageFS)(nil)
+	_ fs.StatFS    = (*imageFS)(nil)
+
+	_ fs.File        = (*file)(nil)
+	_ fs.ReadDirFile = (*dir)(nil)
+)
+
+// FS exposes the image as a read-only io/fs.FS, backed by random-access reads
+// into the underlying Device. Paths are resolved through the Rock Ridge tree,
+// the same one UpdateFile edits.
+func (img *Image) FS() fs.FS {
+	return &imageFS{img}
+}
+
+func (ifs *imageFS) resolve(name string) (*directoryRecord, error) {
+	if name == "." {
+		return ifs.img.getRootDirectoryRecord()
+	}
+	return ifs.img.findDirectoryRecord("/" + name)
+}
+
+func (ifs *imageFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	r, err := ifs.resolve(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	if r.isDir() {
+		children, err := ifs.img.getChildren(r)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &dir{name: baseName(name, r), record: r, entries: visibleChildren(children)}, nil
+	}
+
+	// Sparse files need their holes expanded before they read back as their
+	// logical content, which getExtent already does - but buffers the whole
+	// extent to do it. Ordinary files stream straight off the Device instead
+	// of paying that memory cost on every Open.
+	var reader io.Reader
+	if r.Sparse {
+		content, err := ifs.img.getExtent(r)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		reader = bytes.NewReader(content)
+	} else {
+		reader = io.NewSectionReader(ifs.img.dev, int64(r.ExtentLocation)*int64(SECTOR_SIZE), int64(r.ExtentSize))
+	}
+
+	return &file{
+		name:   baseName(name, r),
+		record: r,
+		r:      reader,
+	}, nil
+}
+
+func (ifs *imageFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	r, err := ifs.resolve(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	if !r.isDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	children, err := ifs.img.getChildren(r)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
+	visible := visibleChildren(children)
+	entries := make([]fs.DirEntry, len(visible))
+	for i, child := range visible {
+		entries[i] = dirEntry{child}
+	}
+	return entries, nil
+}
+
+func (ifs *imageFS) Stat(name string) (fs.FileInfo, error) {
+	r, err := ifs.resolve(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return fileInfo{baseName(name, r), r}, nil
+}
+
+// visibleChildren drops the "." (0x00) and ".." (0x01) self/parent records
+// that getChildren otherwise returns verbatim.
+func visibleChildren(children []*directoryRecord) []*directoryRecord {
+	visible := make([]*directoryRecord, 0, len(children))
+	for _, child := range children {
+		if child.Identifier == "\x00" || child.Identifier == "\x01" {
+			continue
+		}
+		visible = append(visible, child)
+	}
+	return visible
+}
+
+func baseName(path string, r *directoryRecord) string {
+	if path == "." {
+		return "."
+	}
+	if r.RockRidgeName != "" {
+		return r.RockRidgeName
+	}
+	return r.Identifier
+}
+
+func (fi fileInfo) Name() string { return fi.name }
+func (fi fileInfo) Size() int64 {
+	if fi.record.Sparse {
+		return int64(fi.record.LogicalSize)
+	}
+	return int64(fi.record.ExtentSize)
+}
+func (fi fileInfo) ModTime() time.Time { return time.Time{} }
+func (fi fileInfo) IsDir() bool        { return fi.record.isDir() }
+func (fi fileInfo) Sys() interface{}   { return nil }
+func (fi fileInfo) Mode() fs.FileMode {
+	if fi.record.isDir() {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
+
+func (e dirEntry) Name() string {
+	if e.record.RockRidgeName != "" {
+		return e.record.RockRidgeName
+	}
+	return e.record.Identifier
+}
+func (e dirEntry) IsDir() bool                { return e.record.isDir() }
+func (e dirEntry) Type() fs.FileMode          { return fileInfo{record: e.record}.Mode().Type() }
+func (e dirEntry) Info() (fs.FileInfo, error) { return fileInfo{e.Name(), e.record}, nil }
+
+func (f *file) Stat() (fs.FileInfo, error) { return fileInfo{f.name, f.record}, nil }
+func (f *file) Read(p []byte) (int, error) { return f.r.Read(p) }
+func (f *file) Close() error               { return nil }
+
+func (d *dir) Stat() (fs.FileInfo, error) { return fileInfo{d.name, d.record}, nil }
+func (d *dir) Close() error               { return nil }
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: fs.ErrInvalid}
+}
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := d.entries[d.offset:]
+
+	if n > 0 && len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if n <= 0 || n > len(remaining) {
+		n = len(remaining)
+	}
+
+	entries := make([]fs.DirEntry, n)
+	for i, child := range remaining[:n] {
+		entries[i] = dirEntry{child}
+	}
+	d.offset += n
+	return entries, nil
+}
@@ -0,0 +1,144 @@
+package patchwork
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"testing"
+)
+
+// sparseSystemUseField builds a Rock Ridge "SF" system-use field in the same
+// layout appendSystemUse parses - the RRIP 13-byte header (signature, length,
+// version, both-endian virtual file size, table depth) followed by this
+// library's own presence-table extension - for tests that need a sparse file
+// without going through a (nonexistent) sparse-file writer.
+func sparseSystemUseField(logicalSize uint64, table []byte) []byte {
+	field := []byte{'S', 'F', 0, 1}
+	field = append(field, marshalUint32(uint32(logicalSize))...)
+	field = append(field, 0) // table depth, unused by this library
+	field = append(field, table...)
+	field[2] = byte(len(field))
+	return field
+}
+
+func TestImageFSOpenExpandsSparseFile(t *testing.T) {
+	img := newTestImage(t)
+
+	physical := bytes.Repeat([]byte{'B'}, int(SECTOR_SIZE))
+	loc := img.allocate(int64(SECTOR_SIZE))
+	if _, err := img.dev.WriteAt(physical, loc); err != nil {
+		t.Fatalf("write physical extent: %v", err)
+	}
+
+	record := newDirectoryRecord("FILE.TXT", "file.txt", false)
+	record.ExtentLocation = uint32(loc) / SECTOR_SIZE
+	record.ExtentSize = SECTOR_SIZE
+	record.appendSystemUse(sparseSystemUseField(uint64(SECTOR_SIZE)*3, []byte{0, 1, 0}))
+
+	root, err := img.getRootDirectoryRecord()
+	if err != nil {
+		t.Fatalf("getRootDirectoryRecord: %v", err)
+	}
+	children, err := img.getChildren(root)
+	if err != nil {
+		t.Fatalf("getChildren(root): %v", err)
+	}
+	children = append(children, record)
+	if err := img.setChildren(root, children); err != nil {
+		t.Fatalf("setChildren(root): %v", err)
+	}
+
+	fsys := img.FS()
+
+	info, err := fs.Stat(fsys, "file.txt")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if want := int64(SECTOR_SIZE) * 3; info.Size() != want {
+		t.Fatalf("Size() = %v, want %v (logical, not the %v-byte physical extent)", info.Size(), want, SECTOR_SIZE)
+	}
+
+	f, err := fsys.Open("file.txt")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+
+	got, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+
+	zero := make([]byte, SECTOR_SIZE)
+	if !bytes.Equal(got[:SECTOR_SIZE], zero) {
+		t.Fatalf("first logical sector should be a hole")
+	}
+	if !bytes.Equal(got[SECTOR_SIZE:2*SECTOR_SIZE], physical) {
+		t.Fatalf("second logical sector should be the present physical block")
+	}
+	if !bytes.Equal(got[2*SECTOR_SIZE:], zero) {
+		t.Fatalf("third logical sector should be a hole")
+	}
+}
+
+// TestImageFSOpenReadsOrdinaryFile covers the plain, non-sparse path through
+// imageFS: a file streamed straight off the Device, plus Stat and ReadDir on
+// its parent directory.
+func TestImageFSOpenReadsOrdinaryFile(t *testing.T) {
+	img := newTestImage(t)
+
+	content := []byte("hello, world")
+	if err := img.AddFile("", "FILE.TXT", "file.txt", content); err != nil {
+		t.Fatalf("AddFile: %v", err)
+	}
+	if err := img.Mkdir("/DIR1", "DIR1", "dir1"); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+
+	fsys := img.FS()
+
+	f, err := fsys.Open("file.txt")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+
+	got, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("got %q, want %q", got, content)
+	}
+
+	info, err := fs.Stat(fsys, "file.txt")
+	if err != nil {
+		t.Fatalf("Stat(file.txt): %v", err)
+	}
+	if info.IsDir() {
+		t.Fatalf("file.txt reports IsDir() = true")
+	}
+	if info.Size() != int64(len(content)) {
+		t.Fatalf("Size() = %v, want %v", info.Size(), len(content))
+	}
+
+	dirInfo, err := fs.Stat(fsys, "dir1")
+	if err != nil {
+		t.Fatalf("Stat(dir1): %v", err)
+	}
+	if !dirInfo.IsDir() {
+		t.Fatalf("dir1 reports IsDir() = false")
+	}
+
+	entries, err := fsys.(fs.ReadDirFS).ReadDir(".")
+	if err != nil {
+		t.Fatalf("ReadDir(.): %v", err)
+	}
+	names := map[string]bool{}
+	for _, e := range entries {
+		names[e.Name()] = true
+	}
+	if !names["file.txt"] || !names["dir1"] {
+		t.Fatalf("ReadDir(.) = %v, want it to include file.txt and dir1", names)
+	}
+}
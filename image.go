@@ -2,12 +2,39 @@ package patchwork
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"os"
 	"sort"
 	"strings"
+	"sync"
 )
 
+// errNoJolietVolume is returned by getSupplementaryVolumeDescriptor when the
+// image has no Joliet Supplementary Volume Descriptor, e.g. it was mastered
+// with Rock Ridge only.
+var errNoJolietVolume = errors.New("no joliet supplementary volume descriptor found")
+
+// errVolumeDescriptorNotFound is returned by findVolumeDescriptor when the
+// volume descriptor set terminator is reached without a match.
+var errVolumeDescriptorNotFound = errors.New("volume descriptor was not found")
+
+// errSectorFull is returned by setChildren/setJolietChildren when the
+// marshalled children no longer fit in the parent's current extent.
+var errSectorFull = errors.New("sector is full")
+
+// errJolietUnsupported is returned by AddFile, DeleteFile and Mkdir when the
+// image has a Joliet tree. Unlike UpdateFile, these three change a
+// directory's set of entries - and can relocate a directory's extent via
+// setChildrenGrowing - without a Joliet-tree counterpart of that growth
+// logic, so allowing them here would either leave the Joliet tree silently
+// stale (added/removed entries) or pointing at a since-reallocated extent
+// (relocated directories). Stick to UpdateFile on a Joliet image, or edit
+// the Rock Ridge tree only and rebuild the Joliet tree separately.
+var errJolietUnsupported = errors.New("AddFile/DeleteFile/Mkdir do not support images with a Joliet tree")
+
 const (
 	SECTOR_SIZE uint32 = 2 * 1024
 )
@@ -16,20 +43,66 @@ type (
 	// Device is data layer which Image write updated contents to and read original contents from.
 	// You can use os.File as Device.
 	Device interface {
-		io.Seeker
 		io.ReaderAt
 		io.WriterAt
 	}
 
+	// Sizer is implemented by devices that can report their current size
+	// without a Seek, e.g. overlay.Overlay. NewImage uses it, when available,
+	// to find where new extents can be allocated.
+	Sizer interface {
+		Size() int64
+	}
+
 	// Image represents rewritable ISO9660 disk image.
 	Image struct {
 		dev Device
+
+		// mu guards end, so concurrent calls that allocate new extents (e.g.
+		// two goroutines both calling UpdateFile) can't race to claim the
+		// same offset.
+		mu  sync.Mutex
+		end int64
 	}
 )
 
 // Create instance which has specified device in it.
-func NewImage(dev Device) *Image {
-	return &Image{dev}
+//
+// dev's current size is probed once, up front, so later extent allocations
+// never need to Seek: dev implementing Sizer (as overlay.Overlay does) is
+// tried first, then a Stat on dev as an *os.File.
+func NewImage(dev Device) (*Image, error) {
+	end, err := deviceSize(dev)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine device size: %w", err)
+	}
+	return &Image{dev: dev, end: end}, nil
+}
+
+func deviceSize(dev Device) (int64, error) {
+	if sizer, ok := dev.(Sizer); ok {
+		return sizer.Size(), nil
+	}
+	if file, ok := dev.(*os.File); ok {
+		info, err := file.Stat()
+		if err != nil {
+			return 0, fmt.Errorf("failed to stat device: %w", err)
+		}
+		return info.Size(), nil
+	}
+	return 0, fmt.Errorf("device implements neither Sizer nor *os.File")
+}
+
+// allocate reserves size bytes at the current end of the image and advances
+// end past them, returning the offset the caller should write the new extent
+// at. It is the concurrency-safe replacement for seeking to io.SeekEnd.
+func (img *Image) allocate(size int64) int64 {
+	img.mu.Lock()
+	defer img.mu.Unlock()
+
+	loc := img.end
+	img.end += size
+	return loc
 }
 
 func (img *Image) setExtent(r *directoryRecord, buf []byte) error {
@@ -47,9 +120,71 @@ func (img *Image) getExtent(r *directoryRecord) ([]byte, error) {
 	} else if n != len(buf) {
 		return nil, fmt.Errorf("failed to read enough data: expected %v bytes to read, but actual %v bytes could be read", len(buf), n)
 	}
+
+	if r.Sparse {
+		return expandSparse(buf, r.sparseTable, r.LogicalSize), nil
+	}
 	return buf, nil
 }
 
+// expandSparse reconstructs a sparse file's logical contents from its
+// physical, compacted extent: physical holds only the blocks table marks
+// present, in order, and every block table marks as a hole reads back as
+// SECTOR_SIZE zero bytes.
+func expandSparse(physical []byte, table []byte, logicalSize uint64) []byte {
+	out := make([]byte, 0, logicalSize)
+
+	pos := 0
+	for _, present := range table {
+		if present == 0 {
+			out = append(out, make([]byte, SECTOR_SIZE)...)
+			continue
+		}
+
+		end := pos + int(SECTOR_SIZE)
+		if end > len(physical) {
+			end = len(physical)
+		}
+		out = append(out, physical[pos:end]...)
+		pos = end
+	}
+
+	switch {
+	case uint64(len(out)) < logicalSize:
+		out = append(out, make([]byte, logicalSize-uint64(len(out)))...)
+	case uint64(len(out)) > logicalSize:
+		out = out[:logicalSize]
+	}
+	return out
+}
+
+// resolveSystemUse follows any Rock Ridge "CE" continuation-area fields on r,
+// fetching the spilled-over system-use bytes from their own extent and
+// folding them into r (e.g. an "SF" field that didn't fit in the directory
+// record itself). Continuation areas may themselves chain to further ones.
+func (img *Image) resolveSystemUse(r *directoryRecord) error {
+	for i := 0; i < len(r.SystemUse); i++ {
+		field := r.SystemUse[i]
+		if string(field[:2]) != "CE" {
+			continue
+		}
+
+		blockLocation := binary.LittleEndian.Uint32(field[4:8])
+		offset := binary.LittleEndian.Uint32(field[12:16])
+		length := binary.LittleEndian.Uint32(field[20:24])
+
+		buf := make([]byte, length)
+		if n, err := img.dev.ReadAt(buf, int64(blockLocation)*int64(SECTOR_SIZE)+int64(offset)); err != nil {
+			return fmt.Errorf("failed to read continuation area: %w", err)
+		} else if n != len(buf) {
+			return fmt.Errorf("failed to read enough data: expected %v bytes to read, but actual %v bytes could be read", len(buf), n)
+		}
+
+		r.appendSystemUse(buf)
+	}
+	return nil
+}
+
 func (img *Image) setChildren(parent *directoryRecord, children []*directoryRecord) error {
 	sort.Slice(children, func(i, j int) bool {
 		return strings.Compare(children[i].Identifier, children[j].Identifier) == -1
@@ -62,7 +197,7 @@ func (img *Image) setChildren(parent *directoryRecord, children []*directoryReco
 
 	paddingSize := int(parent.ExtentSize) - len(buf)
 	if paddingSize < 0 {
-		return fmt.Errorf("sector is full: exceeds %v bytes", -paddingSize)
+		return fmt.Errorf("%w: exceeds %v bytes", errSectorFull, -paddingSize)
 	}
 
 	buf = append(buf, bytes.Repeat([]byte{0}, paddingSize)...)
@@ -81,33 +216,90 @@ func (img *Image) getChildren(r *directoryRecord) ([]*directoryRecord, error) {
 	children := []*directoryRecord{}
 	for i := uint32(0); buf[i] > 0; i += uint32(buf[i]) {
 		// Each record has its size at first byte.
-		children = append(children, unmarshalDirectoryRecord(buf[i:i+uint32(buf[i])]))
+		child := unmarshalDirectoryRecord(buf[i : i+uint32(buf[i])])
+		if err := img.resolveSystemUse(child); err != nil {
+			return nil, fmt.Errorf("failed to resolve system use: %w", err)
+		}
+		children = append(children, child)
 	}
 	return children, nil
 }
 
-func (img *Image) getVolumeDescriptor() ([]byte, error) {
+// findVolumeDescriptor scans the volume descriptor set (starting at sector
+// 16, up to the set terminator) for the first sector matching match, and
+// returns its contents along with the absolute sector number it came from.
+func (img *Image) findVolumeDescriptor(match func(buf []byte) bool) ([]byte, uint32, error) {
 	buf := make([]byte, SECTOR_SIZE)
 
 	// First 16 sector is reserved area. Next some sector can be volume descriptor.
 	for i := uint32(0); ; i++ {
-		if n, err := img.dev.ReadAt(buf, int64((i+16)*SECTOR_SIZE)); err != nil {
-			return nil, fmt.Errorf("failed to read sector from image: %w", err)
+		sector := i + 16
+		if n, err := img.dev.ReadAt(buf, int64(sector)*int64(SECTOR_SIZE)); err != nil {
+			return nil, 0, fmt.Errorf("failed to read sector from image: %w", err)
 		} else if n != len(buf) {
-			return nil, fmt.Errorf("failed to read enough data: expected %v bytes to read, but actual %v bytes could be read", len(buf), n)
+			return nil, 0, fmt.Errorf("failed to read enough data: expected %v bytes to read, but actual %v bytes could be read", len(buf), n)
 		}
 
 		// Volume descriptor type codes, which is located in first byte of sector, is volume descriptor set ternimator.
 		if buf[0] == 255 {
-			break
+			return nil, 0, errVolumeDescriptorNotFound
 		}
 
-		// Type code 1 (Primary) or 2 (Supplementary) is fine.
-		if buf[0] == 1 || buf[0] == 2 {
-			return buf, nil
+		if match(buf) {
+			return append([]byte{}, buf...), sector, nil
+		}
+	}
+}
+
+func (img *Image) getVolumeDescriptor() ([]byte, error) {
+	// Type code 1 (Primary) or 2 (Supplementary) is fine.
+	buf, _, err := img.findVolumeDescriptor(func(buf []byte) bool {
+		return buf[0] == 1 || buf[0] == 2
+	})
+	return buf, err
+}
+
+// getSupplementaryVolumeDescriptor locates the Joliet Supplementary Volume
+// Descriptor: type code 2 whose escape sequence field [88:120] advertises one
+// of the UCS-2 Level 1/2/3 escape sequences (%/@, %/C, %/E). It returns
+// errNoJolietVolume if the image was not mastered with Joliet.
+func (img *Image) getSupplementaryVolumeDescriptor() ([]byte, error) {
+	buf, _, err := img.findVolumeDescriptor(func(buf []byte) bool {
+		return buf[0] == 2 && isJolietEscapeSequence(buf[88:120])
+	})
+	if errors.Is(err, errVolumeDescriptorNotFound) {
+		return nil, errNoJolietVolume
+	}
+	return buf, err
+}
+
+// setRootDirectoryRecord writes r back into the Primary Volume Descriptor's
+// embedded root directory record, at [156:190]. Unlike every other directory
+// record, the root's is not stored among some parent's children, so growing
+// its extent (see setChildrenGrowing) needs its own write path.
+func (img *Image) setRootDirectoryRecord(r *directoryRecord) error {
+	buf, sector, err := img.findVolumeDescriptor(func(buf []byte) bool { return buf[0] == 1 })
+	if err != nil {
+		return fmt.Errorf("failed to find primary volume descriptor: %w", err)
+	}
+
+	copy(buf[156:190], r.marshal())
+
+	if n, err := img.dev.WriteAt(buf, int64(sector)*int64(SECTOR_SIZE)); err != nil {
+		return fmt.Errorf("failed to write volume descriptor: %w", err)
+	} else if n != len(buf) {
+		return fmt.Errorf("failed to write enough data: expected %v bytes to write, but actual %v bytes could be written", len(buf), n)
+	}
+	return nil
+}
+
+func isJolietEscapeSequence(esc []byte) bool {
+	for _, seq := range [][]byte{[]byte("%/@"), []byte("%/C"), []byte("%/E")} {
+		if bytes.Contains(esc, seq) {
+			return true
 		}
 	}
-	return nil, fmt.Errorf("volume descriptor was not found")
+	return false
 }
 
 func (img *Image) getRootDirectoryRecord() (*directoryRecord, error) {
@@ -117,7 +309,129 @@ func (img *Image) getRootDirectoryRecord() (*directoryRecord, error) {
 	}
 
 	// DirectoryRecord of root directory is located in [156:190] of volume descriptor
-	return unmarshalDirectoryRecord(vd[156:190]), nil
+	r := unmarshalDirectoryRecord(vd[156:190])
+	if err := img.resolveSystemUse(r); err != nil {
+		return nil, fmt.Errorf("failed to resolve system use: %w", err)
+	}
+	return r, nil
+}
+
+func (img *Image) getJolietRootDirectoryRecord() (*directoryRecord, error) {
+	vd, err := img.getSupplementaryVolumeDescriptor()
+	if err != nil {
+		return nil, err
+	}
+
+	// DirectoryRecord of root directory is located in [156:190] of volume descriptor
+	return unmarshalJolietDirectoryRecord(vd[156:190]), nil
+}
+
+func (img *Image) getJolietChildren(r *directoryRecord) ([]*directoryRecord, error) {
+	buf, err := img.getExtent(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get extent: %w", err)
+	}
+
+	children := []*directoryRecord{}
+	for i := uint32(0); buf[i] > 0; i += uint32(buf[i]) {
+		// Each record has its size at first byte.
+		children = append(children, unmarshalJolietDirectoryRecord(buf[i:i+uint32(buf[i])]))
+	}
+	return children, nil
+}
+
+func (img *Image) setJolietChildren(parent *directoryRecord, children []*directoryRecord) error {
+	sort.Slice(children, func(i, j int) bool {
+		return strings.Compare(children[i].JolietName, children[j].JolietName) == -1
+	})
+
+	buf := make([]byte, 0, parent.ExtentSize)
+	for _, child := range children {
+		buf = append(buf, child.marshalJoliet()...)
+	}
+
+	paddingSize := int(parent.ExtentSize) - len(buf)
+	if paddingSize < 0 {
+		return fmt.Errorf("%w: exceeds %v bytes", errSectorFull, -paddingSize)
+	}
+
+	buf = append(buf, bytes.Repeat([]byte{0}, paddingSize)...)
+
+	if err := img.setExtent(parent, buf); err != nil {
+		return fmt.Errorf("failed to set extent: %w", err)
+	}
+	return nil
+}
+
+// findJolietRecordByExtent searches the Joliet tree, starting at dir, for the
+// record whose ExtentLocation matches extentLocation. The primary (Rock
+// Ridge) and Joliet trees are distinct directory hierarchies that point at
+// the same file extents, so this is how a file updated in one tree is found
+// in the other.
+func (img *Image) findJolietRecordByExtent(dir *directoryRecord, extentLocation uint32) (*directoryRecord, *directoryRecord, []*directoryRecord, error) {
+	children, err := img.getJolietChildren(dir)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get joliet children: %w", err)
+	}
+
+	for _, child := range children {
+		// Skip "." and ".." entries to avoid recursing back up/into ourselves.
+		if child.Identifier == "\x00" || child.Identifier == "\x01" {
+			continue
+		}
+
+		if child.ExtentLocation == extentLocation {
+			return child, dir, children, nil
+		}
+
+		if child.isDir() {
+			if found, parent, siblings, err := img.findJolietRecordByExtent(child, extentLocation); err == nil {
+				return found, parent, siblings, nil
+			}
+		}
+	}
+
+	return nil, nil, nil, fmt.Errorf("no such entry in joliet tree: extent %v", extentLocation)
+}
+
+// mirrorToJoliet propagates an UpdateFile change into the Joliet tree, if the
+// image has one. The file being updated is found by its previous extent
+// location, since that is the one thing the primary and Joliet records for
+// the same file always share.
+func (img *Image) mirrorToJoliet(oldExtentLocation uint32, name string, newExtentLocation, newExtentSize uint32) error {
+	root, err := img.getJolietRootDirectoryRecord()
+	if errors.Is(err, errNoJolietVolume) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to find joliet root: %w", err)
+	}
+
+	target, parent, siblings, err := img.findJolietRecordByExtent(root, oldExtentLocation)
+	if err != nil {
+		return fmt.Errorf("failed to find joliet entry: %w", err)
+	}
+
+	target.JolietName = name
+	target.ExtentLocation = newExtentLocation
+	target.ExtentSize = newExtentSize
+
+	if err := img.setJolietChildren(parent, siblings); err != nil {
+		return fmt.Errorf("failed to update joliet directory record: %w", err)
+	}
+	return nil
+}
+
+// requireNoJoliet returns errJolietUnsupported if the image has a Joliet
+// tree, and nil otherwise. See errJolietUnsupported for why.
+func (img *Image) requireNoJoliet() error {
+	_, err := img.getJolietRootDirectoryRecord()
+	if errors.Is(err, errNoJolietVolume) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check for joliet volume: %w", err)
+	}
+	return errJolietUnsupported
 }
 
 func (img *Image) findRecordFromChildren(pwd *directoryRecord, key string) (*directoryRecord, []*directoryRecord, error) {
@@ -161,8 +475,13 @@ func (img *Image) findDirectoryRecord(path string) (*directoryRecord, error) {
 // id is new filename, which is used when the image read as raw-ISO9660 filesystem.
 //
 // name is also new filename, which is used when the image read as ISO9660 with RockRidge extention.
+// It also becomes the file's name in the Joliet tree, if the image has one.
 //
 // data is a content which will be written.
+//
+// If the image also has a Joliet (Supplementary Volume Descriptor) tree, the
+// matching entry there - found by its current, shared extent location - is
+// updated too, so the image stays readable through either tree.
 func (img *Image) UpdateFile(path, id, name string, data []byte) error {
 	dirs := strings.Split(path, "/")
 
@@ -176,21 +495,30 @@ func (img *Image) UpdateFile(path, id, name string, data []byte) error {
 		return fmt.Errorf("failed to find target: %w", err)
 	}
 
+	oldExtentLocation := target.ExtentLocation
+
 	target.Identifier = id
 	target.RockRidgeName = name
 
-	loc, err := img.dev.Seek(0, io.SeekEnd)
-	if err != nil {
-		return fmt.Errorf("failed to seek device: %w", err)
+	// UpdateFile always writes data verbatim to a fresh extent; it has no
+	// sparse-file writer. A sparse target is therefore transparently
+	// rewritten as a plain, non-sparse file rather than leaving a stale SF
+	// table that no longer matches the new content.
+	if target.Sparse {
+		target.Sparse = false
+		target.LogicalSize = 0
+		target.SystemUse = stripSparseFields(target.SystemUse)
 	}
 
 	target.ExtentSize = uint32(len(data))
-	target.ExtentLocation = uint32(loc) / SECTOR_SIZE
 
 	if len(data)%int(SECTOR_SIZE) != 0 {
 		data = append(data, bytes.Repeat([]byte{0}, int(SECTOR_SIZE)-len(data)%int(SECTOR_SIZE))...)
 	}
 
+	loc := img.allocate(int64(len(data)))
+	target.ExtentLocation = uint32(loc) / SECTOR_SIZE
+
 	if _, err := img.dev.WriteAt(data, loc); err != nil {
 		return fmt.Errorf("failed to write file data: %w", err)
 	}
@@ -199,5 +527,238 @@ func (img *Image) UpdateFile(path, id, name string, data []byte) error {
 		return fmt.Errorf("failed to update directory record: %w", err)
 	}
 
+	if err := img.mirrorToJoliet(oldExtentLocation, name, target.ExtentLocation, target.ExtentSize); err != nil {
+		return fmt.Errorf("failed to mirror update to joliet tree: %w", err)
+	}
+
+	return nil
+}
+
+// setChildrenGrowing is setChildren, except that when the marshalled children
+// no longer fit in dir's current extent, dir's extent is relocated to a fresh,
+// larger sector at end-of-device before retrying. dirPath is the path to dir
+// itself (as accepted by findDirectoryRecord, so "" for the root), and is used
+// to find and rewrite dir's own directory record in its parent after the
+// relocation.
+func (img *Image) setChildrenGrowing(dirPath string, dir *directoryRecord, children []*directoryRecord) error {
+	err := img.setChildren(dir, children)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, errSectorFull) {
+		return err
+	}
+
+	dir.ExtentSize += SECTOR_SIZE
+	loc := img.allocate(int64(dir.ExtentSize))
+	dir.ExtentLocation = uint32(loc) / SECTOR_SIZE
+
+	if dirPath == "" {
+		if err := img.setRootDirectoryRecord(dir); err != nil {
+			return fmt.Errorf("failed to update root directory record: %w", err)
+		}
+	} else {
+		dirs := strings.Split(dirPath, "/")
+		grandparentPath := strings.Join(dirs[:len(dirs)-1], "/")
+
+		grandparent, err := img.findDirectoryRecord(grandparentPath)
+		if err != nil {
+			return fmt.Errorf("failed to find grandparent directory: %w", err)
+		}
+
+		self, siblings, err := img.findRecordFromChildren(grandparent, dirs[len(dirs)-1])
+		if err != nil {
+			return fmt.Errorf("failed to find directory's own record: %w", err)
+		}
+		self.ExtentLocation = dir.ExtentLocation
+		self.ExtentSize = dir.ExtentSize
+
+		if err := img.setChildrenGrowing(grandparentPath, grandparent, siblings); err != nil {
+			return fmt.Errorf("failed to update grandparent directory record: %w", err)
+		}
+	}
+
+	// children still holds dir's own "." entry (and any subdirectory
+	// children) pointing at dir's old, now-stale extent. Fix those up before
+	// writing children into dir's new extent.
+	for _, child := range children {
+		if child.Identifier == "\x00" {
+			child.ExtentLocation = dir.ExtentLocation
+			child.ExtentSize = dir.ExtentSize
+			continue
+		}
+		if child.Identifier == "\x01" || !child.isDir() {
+			continue
+		}
+		if err := img.fixParentPointer(child, dir.ExtentLocation, dir.ExtentSize); err != nil {
+			return fmt.Errorf("failed to fix up child directory's parent pointer: %w", err)
+		}
+	}
+
+	return img.setChildren(dir, children)
+}
+
+// fixParentPointer rewrites child's own ".." entry to point at the given
+// extent, used when child's parent directory has just been relocated by
+// setChildrenGrowing.
+func (img *Image) fixParentPointer(child *directoryRecord, parentExtentLocation, parentExtentSize uint32) error {
+	grandchildren, err := img.getChildren(child)
+	if err != nil {
+		return fmt.Errorf("failed to get children: %w", err)
+	}
+
+	changed := false
+	for _, grandchild := range grandchildren {
+		if grandchild.Identifier == "\x01" {
+			grandchild.ExtentLocation = parentExtentLocation
+			grandchild.ExtentSize = parentExtentSize
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	return img.setChildren(child, grandchildren)
+}
+
+// AddFile adds a new file to an existing directory in the image.
+//
+// dir is the path of the directory the file will be added to, following the
+// same convention as UpdateFile's parent resolution (e.g. "" for the root,
+// "/EFI/BOOT" for a subdirectory).
+//
+// id and name are the new file's raw-ISO9660 and RockRidge identifiers,
+// respectively, as in UpdateFile.
+//
+// data is the content which will be written.
+//
+// AddFile is Rock-Ridge-only: unlike UpdateFile, it does not mirror the new
+// entry into a Joliet tree, and returns errJolietUnsupported if the image
+// has one.
+func (img *Image) AddFile(dir, id, name string, data []byte) error {
+	if err := img.requireNoJoliet(); err != nil {
+		return err
+	}
+
+	parent, err := img.findDirectoryRecord(dir)
+	if err != nil {
+		return fmt.Errorf("failed to find parent directory: %w", err)
+	}
+
+	children, err := img.getChildren(parent)
+	if err != nil {
+		return fmt.Errorf("failed to get children: %w", err)
+	}
+
+	record := newDirectoryRecord(id, name, false)
+	record.ExtentSize = uint32(len(data))
+
+	if len(data)%int(SECTOR_SIZE) != 0 {
+		data = append(data, bytes.Repeat([]byte{0}, int(SECTOR_SIZE)-len(data)%int(SECTOR_SIZE))...)
+	}
+
+	loc := img.allocate(int64(len(data)))
+	record.ExtentLocation = uint32(loc) / SECTOR_SIZE
+
+	if _, err := img.dev.WriteAt(data, loc); err != nil {
+		return fmt.Errorf("failed to write file data: %w", err)
+	}
+
+	children = append(children, record)
+	if err := img.setChildrenGrowing(dir, parent, children); err != nil {
+		return fmt.Errorf("failed to update directory record: %w", err)
+	}
+	return nil
+}
+
+// DeleteFile removes the entry at path from the image. Its data extent is
+// left on disk untouched; only the directory record referencing it is
+// removed.
+//
+// DeleteFile is Rock-Ridge-only: unlike UpdateFile, it does not mirror the
+// removal into a Joliet tree, and returns errJolietUnsupported if the image
+// has one.
+func (img *Image) DeleteFile(path string) error {
+	if err := img.requireNoJoliet(); err != nil {
+		return err
+	}
+
+	dirs := strings.Split(path, "/")
+	parentPath := strings.Join(dirs[:len(dirs)-1], "/")
+
+	parent, err := img.findDirectoryRecord(parentPath)
+	if err != nil {
+		return fmt.Errorf("failed to find parent directory: %w", err)
+	}
+
+	target, children, err := img.findRecordFromChildren(parent, dirs[len(dirs)-1])
+	if err != nil {
+		return fmt.Errorf("failed to find target: %w", err)
+	}
+
+	remaining := make([]*directoryRecord, 0, len(children)-1)
+	for _, child := range children {
+		if child == target {
+			continue
+		}
+		remaining = append(remaining, child)
+	}
+
+	if err := img.setChildrenGrowing(parentPath, parent, remaining); err != nil {
+		return fmt.Errorf("failed to update directory record: %w", err)
+	}
+	return nil
+}
+
+// Mkdir creates a new, empty directory in the image.
+//
+// path is the path of the directory to create (e.g. /EFI/NEWDIR), id and name
+// are its raw-ISO9660 and RockRidge identifiers, as in AddFile.
+//
+// Mkdir is Rock-Ridge-only: unlike UpdateFile, it does not mirror the new
+// directory into a Joliet tree, and returns errJolietUnsupported if the
+// image has one.
+func (img *Image) Mkdir(path, id, name string) error {
+	if err := img.requireNoJoliet(); err != nil {
+		return err
+	}
+
+	dirs := strings.Split(path, "/")
+	parentPath := strings.Join(dirs[:len(dirs)-1], "/")
+
+	parent, err := img.findDirectoryRecord(parentPath)
+	if err != nil {
+		return fmt.Errorf("failed to find parent directory: %w", err)
+	}
+
+	children, err := img.getChildren(parent)
+	if err != nil {
+		return fmt.Errorf("failed to get children: %w", err)
+	}
+
+	record := newDirectoryRecord(id, name, true)
+	record.ExtentSize = SECTOR_SIZE
+	loc := img.allocate(int64(record.ExtentSize))
+	record.ExtentLocation = uint32(loc) / SECTOR_SIZE
+
+	// A directory's extent always starts with "." and ".." records, pointing
+	// at its own extent and its parent's.
+	self := newDirectoryRecord("\x00", "", true)
+	self.ExtentLocation = record.ExtentLocation
+	self.ExtentSize = record.ExtentSize
+
+	parentDot := newDirectoryRecord("\x01", "", true)
+	parentDot.ExtentLocation = parent.ExtentLocation
+	parentDot.ExtentSize = parent.ExtentSize
+
+	if err := img.setChildren(record, []*directoryRecord{self, parentDot}); err != nil {
+		return fmt.Errorf("failed to initialize directory extent: %w", err)
+	}
+
+	children = append(children, record)
+	if err := img.setChildrenGrowing(parentPath, parent, children); err != nil {
+		return fmt.Errorf("failed to update directory record: %w", err)
+	}
 	return nil
 }
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
+	"sync"
 )
 
 type (
@@ -22,7 +24,16 @@ type (
 	Overlay struct {
 		base   Base
 		cursor int64
-		end    int64
+
+		// mu guards end and layers, so an Overlay can be passed as a Device to
+		// concurrent callers (e.g. multiple Image.UpdateFile calls sharing one
+		// writable overlay).
+		mu  sync.Mutex
+		end int64
+
+		// layers is kept sorted by offset and non-overlapping: every WriteAt
+		// merges/splits existing layers so later writes always win over
+		// earlier ones on overlapping ranges.
 		layers []*layer
 	}
 
@@ -32,13 +43,17 @@ type (
 	}
 )
 
+func (l *layer) end() int64 {
+	return l.offset + int64(len(l.data))
+}
+
 // Create new instance with specified base layer.
 func New(base Base) (*Overlay, error) {
 	end, err := base.Seek(0, io.SeekEnd)
 	if err != nil {
 		return nil, fmt.Errorf("failed to seek base: %w", err)
 	}
-	return &Overlay{base, 0, end, []*layer{}}, nil
+	return &Overlay{base: base, cursor: 0, end: end}, nil
 }
 
 // Create new instance which has os.File as base layer.
@@ -51,20 +66,24 @@ func NewFromFile(file string) (*Overlay, error) {
 }
 
 func (o *Overlay) Close() error {
-	if closer, ok := interface{}(o).(io.Closer); ok {
+	if closer, ok := o.base.(io.Closer); ok {
 		return closer.Close()
 	}
 	return nil
 }
 
 func (o *Overlay) Size() int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
 	return o.end
 }
 
 func (o *Overlay) Seek(offset int64, whence int) (int64, error) {
 	switch whence {
 	case io.SeekEnd:
+		o.mu.Lock()
 		o.cursor = offset + o.end
+		o.mu.Unlock()
 	case io.SeekStart:
 		o.cursor = offset
 	case io.SeekCurrent:
@@ -79,38 +98,136 @@ func (o *Overlay) Write(p []byte) (int, error) {
 	return n, err
 }
 func (o *Overlay) WriteAt(p []byte, off int64) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
 	if off > o.end {
 		return 0, fmt.Errorf("sparse writing is prohibited: current end is %v, but attempted to write %v", o.end, off)
 	}
-	if off == o.end {
-		o.end += int64(len(p))
+	if end := off + int64(len(p)); end > o.end {
+		o.end = end
 	}
-	o.layers = append(o.layers, &layer{p, off})
+
+	o.insertLayer(&layer{data: append([]byte(nil), p...), offset: off})
 	return len(p), nil
 }
 
+// insertLayer merges l into o.layers, which is kept sorted by offset and
+// non-overlapping. Any existing layer data that l overlaps is trimmed or
+// split, since l - being the more recent write - takes priority. Callers
+// must hold o.mu.
+func (o *Overlay) insertLayer(l *layer) {
+	if len(l.data) == 0 {
+		return
+	}
+
+	kept := make([]*layer, 0, len(o.layers)+1)
+	for _, existing := range o.layers {
+		if existing.end() <= l.offset || existing.offset >= l.end() {
+			kept = append(kept, existing)
+			continue
+		}
+
+		if existing.offset < l.offset {
+			kept = append(kept, &layer{data: existing.data[:l.offset-existing.offset], offset: existing.offset})
+		}
+		if existing.end() > l.end() {
+			kept = append(kept, &layer{data: existing.data[l.end()-existing.offset:], offset: l.end()})
+		}
+	}
+	kept = append(kept, l)
+
+	sort.Slice(kept, func(i, j int) bool { return kept[i].offset < kept[j].offset })
+	o.layers = coalesceAdjacent(kept)
+}
+
+// coalesceAdjacent merges layers that butt up against each other (no gap, no
+// overlap) into a single layer, so layers stays compact as nearby writes
+// accumulate.
+func coalesceAdjacent(layers []*layer) []*layer {
+	if len(layers) == 0 {
+		return layers
+	}
+
+	merged := make([]*layer, 0, len(layers))
+	cur := layers[0]
+	for _, next := range layers[1:] {
+		if cur.end() == next.offset {
+			cur = &layer{data: append(append([]byte(nil), cur.data...), next.data...), offset: cur.offset}
+			continue
+		}
+		merged = append(merged, cur)
+		cur = next
+	}
+	return append(merged, cur)
+}
+
 func (o *Overlay) Read(p []byte) (int, error) {
 	n, err := o.ReadAt(p, o.cursor)
 	o.cursor += int64(n)
 	return n, err
 }
 func (o *Overlay) ReadAt(p []byte, off int64) (int, error) {
-	n, err := o.base.ReadAt(p, off)
-	if err != nil && !errors.Is(err, io.EOF) {
-		return n, fmt.Errorf("an error occurs in base layer: %w", err)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if off >= o.end {
+		return 0, io.EOF
+	}
+
+	n := len(p)
+	if avail := o.end - off; int64(n) > avail {
+		n = int(avail)
+	}
+
+	if _, err := o.base.ReadAt(p[:n], off); err != nil && !errors.Is(err, io.EOF) {
+		return 0, fmt.Errorf("an error occurs in base layer: %w", err)
 	}
 
-	for _, layer := range o.layers {
-		if layer.offset <= off && off <= layer.offset+int64(len(layer.data)) {
-			n += copy(p, layer.data[off-layer.offset:])
-		} else if off <= layer.offset && layer.offset <= off+int64(len(p)) {
-			n += copy(p[layer.offset-off:], layer.data)
+	// Binary-search to the first layer that can possibly overlap [off, off+n).
+	end := off + int64(n)
+	i := sort.Search(len(o.layers), func(i int) bool { return o.layers[i].end() > off })
+	for ; i < len(o.layers) && o.layers[i].offset < end; i++ {
+		l := o.layers[i]
+
+		lo, hi := l.offset, l.end()
+		if lo < off {
+			lo = off
+		}
+		if hi > end {
+			hi = end
 		}
+
+		copy(p[lo-off:hi-off], l.data[lo-l.offset:hi-l.offset])
 	}
 
 	if n < len(p) {
 		return n, io.EOF
-	} else {
-		return len(p), nil
 	}
+	return n, nil
+}
+
+// Commit writes every pending layer to w, collapsing this overlay's diffs
+// down into it - the same way union filesystems commit an upper-dir back
+// into the lower layer. w is typically the same device used as Base.
+func (o *Overlay) Commit(w io.WriterAt) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, l := range o.layers {
+		if n, err := w.WriteAt(l.data, l.offset); err != nil {
+			return fmt.Errorf("failed to write layer at offset %v: %w", l.offset, err)
+		} else if n != len(l.data) {
+			return fmt.Errorf("failed to write enough data: expected %v bytes to write, but actual %v bytes could be written", len(l.data), n)
+		}
+	}
+	return nil
+}
+
+// Flatten discards all pending layers, as if they had just been Commit-ed
+// into the base and the base were re-opened.
+func (o *Overlay) Flatten() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.layers = nil
 }
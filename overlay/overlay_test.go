@@ -0,0 +1,123 @@
+package overlay
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+)
+
+// memBase is a minimal Base backed by an in-memory buffer, for tests that
+// don't need a real file.
+type memBase struct {
+	data []byte
+}
+
+func (m *memBase) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m *memBase) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekEnd:
+		return int64(len(m.data)) + offset, nil
+	case io.SeekStart:
+		return offset, nil
+	default:
+		return 0, nil
+	}
+}
+
+func newTestOverlay(t *testing.T, base []byte) *Overlay {
+	t.Helper()
+	o, err := New(&memBase{data: base})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return o
+}
+
+func TestOverlayReadWriteLayering(t *testing.T) {
+	o := newTestOverlay(t, bytes.Repeat([]byte{'-'}, 16))
+
+	if _, err := o.WriteAt([]byte("AAAA"), 2); err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+	// Overlapping, later write should win over the overlapping portion.
+	if _, err := o.WriteAt([]byte("BB"), 4); err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+
+	got := make([]byte, 16)
+	if _, err := o.ReadAt(got, 0); err != nil && err != io.EOF {
+		t.Fatalf("ReadAt: %v", err)
+	}
+
+	want := "--AABB----------"
+	if string(got) != want {
+		t.Fatalf("ReadAt = %q, want %q", got, want)
+	}
+
+	if len(o.layers) != 1 {
+		t.Fatalf("expected adjacent writes to coalesce into 1 layer, got %d", len(o.layers))
+	}
+}
+
+func TestOverlaySplitsAroundNarrowerWrite(t *testing.T) {
+	o := newTestOverlay(t, bytes.Repeat([]byte{'-'}, 10))
+
+	if _, err := o.WriteAt([]byte("AAAAAAAAAA"), 0); err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+	if _, err := o.WriteAt([]byte("BB"), 4); err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+
+	got := make([]byte, 10)
+	if _, err := o.ReadAt(got, 0); err != nil && err != io.EOF {
+		t.Fatalf("ReadAt: %v", err)
+	}
+
+	want := "AAAABBAAAA"
+	if string(got) != want {
+		t.Fatalf("ReadAt = %q, want %q", got, want)
+	}
+
+	// The split pieces on either side of the middle write butt up against it
+	// again, so coalesceAdjacent should merge everything back into one layer.
+	if len(o.layers) != 1 {
+		t.Fatalf("expected split pieces to recoalesce into 1 layer, got %d", len(o.layers))
+	}
+}
+
+func TestOverlayConcurrentWriteAt(t *testing.T) {
+	o := newTestOverlay(t, bytes.Repeat([]byte{0}, 1024))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		off := int64(i * 16)
+		wg.Add(1)
+		go func(off int64) {
+			defer wg.Done()
+			if _, err := o.WriteAt(bytes.Repeat([]byte{'x'}, 16), off); err != nil {
+				t.Errorf("WriteAt(%d): %v", off, err)
+			}
+		}(off)
+	}
+	wg.Wait()
+
+	got := make([]byte, 1024)
+	if _, err := o.ReadAt(got, 0); err != nil && err != io.EOF {
+		t.Fatalf("ReadAt: %v", err)
+	}
+	if !bytes.Equal(got, bytes.Repeat([]byte{'x'}, 1024)) {
+		t.Fatalf("ReadAt did not reflect all concurrent writes")
+	}
+}
@@ -2,6 +2,8 @@ package patchwork
 
 import (
 	"encoding/binary"
+	"time"
+	"unicode/utf16"
 )
 
 type (
@@ -14,6 +16,20 @@ type (
 
 		RockRidgeName string
 		SystemUse     [][]byte
+
+		// JolietName is the file identifier decoded from the Supplementary Volume
+		// Descriptor (Joliet) directory tree, when this record was unmarshalled
+		// with unmarshalJolietDirectoryRecord. It is empty for records read from
+		// the primary tree.
+		JolietName string
+
+		// Sparse and LogicalSize are populated from a Rock Ridge "SF" system-use
+		// field: Sparse files store only their non-zero blocks in ExtentSize
+		// bytes on disk, but read back as LogicalSize bytes once the zero runs
+		// recorded in sparseTable are expanded. See Image.getExtent.
+		Sparse      bool
+		LogicalSize uint64
+		sparseTable []byte
 	}
 )
 
@@ -25,6 +41,57 @@ func marshalUint32(i uint32) []byte {
 	return append(le, be...)
 }
 
+func marshalUint16(i uint16) []byte {
+	le := make([]byte, 2)
+	binary.LittleEndian.PutUint16(le, i)
+	be := make([]byte, 2)
+	binary.BigEndian.PutUint16(be, i)
+	return append(le, be...)
+}
+
+// newDirectoryRecord builds a fresh directoryRecord for a brand-new entry:
+// it fills in the fixed [18:32] metadata block (recording date, file flags,
+// file unit size, interleave gap size, volume sequence number) with sensible
+// defaults, and - when name is non-empty - attaches a minimal Rock Ridge NM
+// system-use field so the RockRidge name round-trips through marshal/unmarshal.
+func newDirectoryRecord(id, name string, isDir bool) *directoryRecord {
+	raw := make([]byte, 32)
+
+	flags := byte(0)
+	if isDir {
+		flags = 0x02
+	}
+
+	now := time.Now()
+	raw[18] = byte(now.Year() - 1900)
+	raw[19] = byte(now.Month())
+	raw[20] = byte(now.Day())
+	raw[21] = byte(now.Hour())
+	raw[22] = byte(now.Minute())
+	raw[23] = byte(now.Second())
+	raw[24] = 0 // GMT offset
+	raw[25] = flags
+	raw[26] = 0 // file unit size
+	raw[27] = 0 // interleave gap size
+	copy(raw[28:32], marshalUint16(1))
+
+	r := &directoryRecord{
+		raw:           raw,
+		Identifier:    id,
+		RockRidgeName: name,
+		SystemUse:     [][]byte{},
+	}
+
+	if name != "" {
+		nm := []byte{'N', 'M', 0, 1, 0}
+		nm = append(nm, name...)
+		nm[2] = byte(len(nm))
+		r.SystemUse = [][]byte{nm}
+	}
+
+	return r
+}
+
 func unmarshalDirectoryRecord(raw []byte) *directoryRecord {
 	r := &directoryRecord{raw: raw}
 
@@ -45,20 +112,52 @@ func unmarshalDirectoryRecord(raw []byte) *directoryRecord {
 	}
 
 	r.SystemUse = [][]byte{}
-	for i := systemUseOffset; i+2 < len(raw); i += int(raw[i+2]) {
+	r.appendSystemUse(raw[systemUseOffset:])
+
+	return r
+}
+
+// appendSystemUse parses a run of SUSP system-use fields out of buf - either
+// the tail of a directory record, or a continuation area fetched by following
+// a "CE" field - and folds recognised ones (NM, SF) into r.
+func (r *directoryRecord) appendSystemUse(buf []byte) {
+	for i := 0; i+2 < len(buf); i += int(buf[i+2]) {
 		// Each system-use field has its size at 3rd byte of field self.
-		fieldLen := int(raw[i+2])
-		field := raw[i : i+fieldLen]
+		fieldLen := int(buf[i+2])
+		if fieldLen == 0 || i+fieldLen > len(buf) {
+			break
+		}
+		field := buf[i : i+fieldLen]
 		r.SystemUse = append(r.SystemUse, field)
 
-		// A field which has `NM` signature is file name defined in Rock Ridge Interchange Protocol.
-		if string(field[:2]) == "NM" {
+		switch string(field[:2]) {
+		case "NM":
+			// A field which has `NM` signature is file name defined in Rock Ridge Interchange Protocol.
 			// Name starts at 6th byte of field.
 			r.RockRidgeName = string(field[5:])
+
+		case "SF":
+			// Rock Ridge "SF" (sparse file) field. Per RRIP, the fixed
+			// header is 13 bytes: signature, length, a version byte, an
+			// 8-byte both-endian virtual file size (one 32-bit value, LE
+			// half, as resolveSystemUse does for CE's fields - ISO9660 extents don't exceed
+			// 4GB anyway), and a 1-byte table depth that this library
+			// ignores, since it doesn't implement RRIP's recursive
+			// multi-extent sparse addressing. Anything past those 13
+			// bytes is this library's own extension: a flat, one-byte-
+			// per-SECTOR_SIZE-block presence table (1 if physically
+			// stored, 0 if a hole), consumed by expandSparse.
+			const sfHeaderLen = 13
+			if fieldLen < sfHeaderLen {
+				break
+			}
+			r.Sparse = true
+			r.LogicalSize = uint64(binary.LittleEndian.Uint32(field[4:8]))
+			if fieldLen > sfHeaderLen {
+				r.sparseTable = append([]byte(nil), field[sfHeaderLen:fieldLen]...)
+			}
 		}
 	}
-
-	return r
 }
 func (r *directoryRecord) marshal() []byte {
 	raw := r.raw[:2]
@@ -92,6 +191,96 @@ func (r *directoryRecord) marshal() []byte {
 	return raw
 }
 
+// stripSparseFields drops Rock Ridge "SF" and "CE" fields from a record's
+// system-use list, used when a sparse file is overwritten with literal data
+// it no longer applies to.
+func stripSparseFields(fields [][]byte) [][]byte {
+	out := make([][]byte, 0, len(fields))
+	for _, field := range fields {
+		switch string(field[:2]) {
+		case "SF", "CE":
+			continue
+		}
+		out = append(out, field)
+	}
+	return out
+}
+
 func (r *directoryRecord) clone() *directoryRecord {
 	return unmarshalDirectoryRecord(r.marshal())
 }
+
+// isDir reports whether the record's file flags (raw[25]) mark it as a directory.
+func (r *directoryRecord) isDir() bool {
+	return r.raw[25]&0x02 != 0
+}
+
+func decodeUCS2BE(b []byte) string {
+	if len(b)%2 != 0 {
+		b = b[:len(b)-1]
+	}
+
+	u16 := make([]uint16, len(b)/2)
+	for i := range u16 {
+		u16[i] = binary.BigEndian.Uint16(b[i*2 : i*2+2])
+	}
+	return string(utf16.Decode(u16))
+}
+
+func encodeUCS2BE(s string) []byte {
+	u16 := utf16.Encode([]rune(s))
+	buf := make([]byte, len(u16)*2)
+	for i, u := range u16 {
+		binary.BigEndian.PutUint16(buf[i*2:i*2+2], u)
+	}
+	return buf
+}
+
+// unmarshalJolietDirectoryRecord parses a directory record from the Joliet
+// (Supplementary Volume Descriptor) tree, where the identifier is encoded as
+// big-endian UCS-2 rather than ASCII and carries no Rock Ridge system-use data.
+func unmarshalJolietDirectoryRecord(raw []byte) *directoryRecord {
+	r := unmarshalDirectoryRecord(raw)
+
+	idLen := raw[32]
+	r.JolietName = decodeUCS2BE(raw[33 : 33+idLen])
+
+	return r
+}
+
+// marshalJoliet mirrors marshal, but writes JolietName as big-endian UCS-2
+// into the identifier field instead of the ASCII Identifier/RockRidgeName.
+func (r *directoryRecord) marshalJoliet() []byte {
+	raw := r.raw[:2]
+
+	raw = append(raw, marshalUint32(r.ExtentLocation)...)
+	raw = append(raw, marshalUint32(r.ExtentSize)...)
+
+	raw = append(raw, r.raw[18:32]...)
+
+	// "." and ".." are encoded as raw 0x00/0x01 bytes in the Joliet tree
+	// too, just like the primary tree - not as UCS-2, which would need two
+	// bytes to say the same thing and would lose the special self/parent
+	// marker ISO9660/Joliet readers (and findJolietRecordByExtent's own
+	// skip check) rely on.
+	var id []byte
+	switch r.Identifier {
+	case "\x00", "\x01":
+		id = []byte(r.Identifier)
+	default:
+		id = encodeUCS2BE(r.JolietName)
+	}
+	raw = append(raw, byte(len(id)))
+	raw = append(raw, id...)
+
+	if len(id)%2 == 0 {
+		raw = append(raw, 0)
+	}
+
+	if len(raw)%2 == 1 {
+		raw = append(raw, 0)
+	}
+
+	raw[0] = byte(len(raw))
+	return raw
+}
@@ -0,0 +1,33 @@
+package patchwork
+
+import "testing"
+
+// TestMarshalJolietRoundTrip checks that an ordinary file's identifier round
+// trips through marshalJoliet/unmarshalJolietDirectoryRecord as UCS-2, and
+// that the "." self entry keeps its raw 0x00 identifier rather than being
+// encoded as UCS-2 - the bug that once made findJolietRecordByExtent recurse
+// into itself forever after a directory's siblings passed through
+// setJolietChildren (see TestUpdateFileMirrorsToJoliet).
+func TestMarshalJolietRoundTrip(t *testing.T) {
+	file := newDirectoryRecord("FILE.TXT", "", false)
+	file.JolietName = "file.txt"
+	file.ExtentLocation = 123
+	file.ExtentSize = 456
+
+	got := unmarshalJolietDirectoryRecord(file.marshalJoliet())
+	if got.JolietName != "file.txt" {
+		t.Fatalf("JolietName = %q, want %q", got.JolietName, "file.txt")
+	}
+	if got.ExtentLocation != 123 || got.ExtentSize != 456 {
+		t.Fatalf("extent = {%v,%v}, want {123,456}", got.ExtentLocation, got.ExtentSize)
+	}
+
+	self := newDirectoryRecord("\x00", "", true)
+	self.ExtentLocation = 18
+	self.ExtentSize = SECTOR_SIZE
+
+	gotSelf := unmarshalJolietDirectoryRecord(self.marshalJoliet())
+	if gotSelf.Identifier != "\x00" {
+		t.Fatalf("self Identifier = %q, want \\x00", gotSelf.Identifier)
+	}
+}